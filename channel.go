@@ -0,0 +1,136 @@
+package link
+
+import "sync"
+
+// Channel holds a named set of sessions and fans a message out to all of
+// them. Create one with Server.NewChannel.
+type Channel struct {
+	server *Server
+	name   string
+
+	mutex    sync.RWMutex
+	sessions map[uint64]*Session
+}
+
+// NewChannel creates and registers a named channel on the server. Calling it
+// again with the same name returns the existing channel.
+func (server *Server) NewChannel(name string) *Channel {
+	server.channelsMutex.Lock()
+	defer server.channelsMutex.Unlock()
+
+	if channel, exists := server.channels[name]; exists {
+		return channel
+	}
+
+	channel := &Channel{
+		server:   server,
+		name:     name,
+		sessions: make(map[uint64]*Session),
+	}
+	server.channels[name] = channel
+	return channel
+}
+
+// GetChannel returns the named channel, or nil if it doesn't exist.
+func (server *Server) GetChannel(name string) *Channel {
+	server.channelsMutex.Lock()
+	defer server.channelsMutex.Unlock()
+
+	return server.channels[name]
+}
+
+// leaveAllChannels removes session from every channel it belongs to. Called
+// from delSession so a closed session never lingers in a channel.
+func (server *Server) leaveAllChannels(session *Session) {
+	server.channelsMutex.Lock()
+	channels := make([]*Channel, 0, len(server.channels))
+	for _, channel := range server.channels {
+		channels = append(channels, channel)
+	}
+	server.channelsMutex.Unlock()
+
+	for _, channel := range channels {
+		channel.Leave(session)
+	}
+}
+
+// Name returns the channel's name.
+func (channel *Channel) Name() string {
+	return channel.name
+}
+
+// Join adds a session to the channel.
+func (channel *Channel) Join(session *Session) {
+	channel.mutex.Lock()
+	defer channel.mutex.Unlock()
+
+	channel.sessions[session.id] = session
+}
+
+// Leave removes a session from the channel.
+func (channel *Channel) Leave(session *Session) {
+	channel.mutex.Lock()
+	defer channel.mutex.Unlock()
+
+	delete(channel.sessions, session.id)
+}
+
+// Len returns the number of sessions currently in the channel.
+func (channel *Channel) Len() int {
+	channel.mutex.RLock()
+	defer channel.mutex.RUnlock()
+
+	return len(channel.sessions)
+}
+
+// Fetch calls callback once for every session currently in the channel.
+func (channel *Channel) Fetch(callback func(*Session)) {
+	channel.mutex.RLock()
+	defer channel.mutex.RUnlock()
+
+	for _, session := range channel.sessions {
+		callback(session)
+	}
+}
+
+// Broadcast encodes msg exactly once via the server's PacketWriter, then
+// makes one non-blocking attempt to enqueue the resulting packet directly on
+// each member's outbound send channel (session.sendChan, sized by
+// Server.SetSendChanSize/DefaultSendChanSize — that buffer is the
+// configurable high-water mark). A member whose channel is already full is
+// treated as a slow consumer: it is dropped from the channel and closed,
+// rather than blocking the broadcast or being encoded redundantly.
+func (channel *Channel) Broadcast(msg interface{}) error {
+	packet, err := channel.server.writer.Write(msg)
+	if err != nil {
+		return err
+	}
+
+	channel.mutex.RLock()
+	sessions := make([]*Session, 0, len(channel.sessions))
+	for _, session := range channel.sessions {
+		sessions = append(sessions, session)
+	}
+	channel.mutex.RUnlock()
+
+	for _, session := range sessions {
+		if !session.enqueuePacket(packet) {
+			channel.Leave(session)
+			session.Close()
+		}
+	}
+
+	return nil
+}
+
+// enqueuePacket makes one non-blocking attempt to push an already-encoded
+// packet onto the session's outbound send channel, bypassing Send's own
+// encoding step. It returns false if the channel was full.
+func (session *Session) enqueuePacket(packet []byte) bool {
+	select {
+	case session.sendChan <- packet:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,173 @@
+package link
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// ClientOptions configures a Client's connection pool and reconnect backoff.
+type ClientOptions struct {
+	PoolSize   int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	Jitter     float64
+	OnSession  func(*Session)
+}
+
+// Client maintains a pool of PoolSize persistent outbound sessions to a
+// single peer, reconnecting with truncated-exponential backoff whenever one
+// of them closes, until the client is explicitly Stop()ed.
+type Client struct {
+	dial     func() (net.Conn, error)
+	protocol PacketProtocol
+	opts     ClientOptions
+
+	mutex    sync.Mutex
+	sessions []*Session
+	next     int
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+	stopWait sync.WaitGroup
+}
+
+// NewClient creates a client and starts PoolSize reconnect loops, each
+// maintaining one outbound session.
+func NewClient(dial func() (net.Conn, error), protocol PacketProtocol, opts ClientOptions) *Client {
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = 1
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = 100 * time.Millisecond
+	}
+
+	client := &Client{
+		dial:     dial,
+		protocol: protocol,
+		opts:     opts,
+		sessions: make([]*Session, opts.PoolSize),
+		stopChan: make(chan struct{}),
+	}
+
+	for i := 0; i < opts.PoolSize; i++ {
+		client.stopWait.Add(1)
+		go client.keepConnected(i)
+	}
+
+	return client
+}
+
+// keepConnected owns the given pool slot: it dials, runs the session until it
+// closes, then reconnects with backoff, until the client is stopped.
+func (client *Client) keepConnected(slot int) {
+	defer client.stopWait.Done()
+
+	backoff := client.opts.MinBackoff
+
+	for {
+		session, err := client.connect()
+		if err != nil {
+			select {
+			case <-client.stopChan:
+				return
+			case <-time.After(client.withJitter(backoff)):
+			}
+			backoff = client.nextBackoff(backoff)
+			continue
+		}
+
+		backoff = client.opts.MinBackoff
+
+		client.mutex.Lock()
+		client.sessions[slot] = session
+		client.mutex.Unlock()
+
+		if client.opts.OnSession != nil {
+			client.opts.OnSession(session)
+		}
+
+		closed := make(chan struct{})
+		session.AddCloseCallback(client, slot, func() { close(closed) })
+
+		select {
+		case <-client.stopChan:
+			session.Close()
+			<-closed
+			return
+		case <-closed:
+		}
+
+		client.mutex.Lock()
+		client.sessions[slot] = nil
+		client.mutex.Unlock()
+	}
+}
+
+func (client *Client) connect() (*Session, error) {
+	conn, err := client.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	session := NewSession(0, conn, client.protocol, DefaultSendChanSize)
+	session.Start()
+	return session, nil
+}
+
+func (client *Client) nextBackoff(current time.Duration) time.Duration {
+	next := current * 2
+	if client.opts.MaxBackoff > 0 && next > client.opts.MaxBackoff {
+		next = client.opts.MaxBackoff
+	}
+	return next
+}
+
+func (client *Client) withJitter(d time.Duration) time.Duration {
+	if client.opts.Jitter <= 0 {
+		return d
+	}
+	delta := float64(d) * client.opts.Jitter
+	return d + time.Duration(rand.Float64()*2*delta-delta)
+}
+
+// Pick returns a live session from the pool, round-robin. It returns nil if
+// no session is currently connected.
+func (client *Client) Pick() *Session {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	for i := 0; i < len(client.sessions); i++ {
+		slot := (client.next + i) % len(client.sessions)
+		if session := client.sessions[slot]; session != nil && !session.IsClosed() {
+			client.next = slot + 1
+			return session
+		}
+	}
+	return nil
+}
+
+// Broadcast sends msg to every currently connected session in the pool.
+func (client *Client) Broadcast(msg interface{}) {
+	client.mutex.Lock()
+	sessions := make([]*Session, 0, len(client.sessions))
+	for _, session := range client.sessions {
+		if session != nil {
+			sessions = append(sessions, session)
+		}
+	}
+	client.mutex.Unlock()
+
+	for _, session := range sessions {
+		session.Send(msg)
+	}
+}
+
+// Stop closes every session in the pool and stops all reconnect loops.
+func (client *Client) Stop() {
+	client.stopOnce.Do(func() {
+		close(client.stopChan)
+	})
+	client.stopWait.Wait()
+}
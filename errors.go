@@ -0,0 +1,8 @@
+package link
+
+import "errors"
+
+// ErrServerClosed is returned by Handle once its accept loop has ended: after
+// Shutdown or Stop, and equally when the listener's Accept fails on its own.
+// The Accept error itself is not returned.
+var ErrServerClosed = errors.New("link: Server closed")
@@ -0,0 +1,230 @@
+package link
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// HeartbeatConfig configures the per-session idle timeout and heartbeat
+// manager installed by Server.SetHeartbeat. Leaving IdleTimeout zero
+// disables idle timeouts; leaving PingInterval zero disables pings.
+type HeartbeatConfig struct {
+	IdleTimeout  time.Duration
+	PingInterval time.Duration
+	PingPacket   func() interface{}
+}
+
+// heartbeatEntry tracks one session's activity for the manager's min-heap.
+// lastActivity resets the idle timer; nextPing is bumped independently each
+// time a ping goes out, so pings don't mask a genuinely idle peer.
+type heartbeatEntry struct {
+	session      *Session
+	lastActivity time.Time
+	nextPing     time.Time
+	index        int
+}
+
+// deadline is the next instant entry needs attention: whichever of its next
+// ping or its idle timeout comes first.
+func (manager *heartbeatManager) deadline(entry *heartbeatEntry) time.Time {
+	deadline := entry.nextPing
+	if manager.config.IdleTimeout > 0 {
+		idleDeadline := entry.lastActivity.Add(manager.config.IdleTimeout)
+		if manager.config.PingInterval == 0 || idleDeadline.Before(deadline) {
+			deadline = idleDeadline
+		}
+	}
+	return deadline
+}
+
+// heartbeatHeap is a container/heap.Interface ordering entries by
+// manager.deadline(entry), so the manager can always wake at the next
+// session that actually needs attention instead of polling every session on
+// a fixed tick. It holds a back-reference to its manager because the
+// ordering depends on manager.config (IdleTimeout vs PingInterval), not on
+// any single field of the entry itself.
+type heartbeatHeap struct {
+	manager *heartbeatManager
+	entries []*heartbeatEntry
+}
+
+func (h heartbeatHeap) Len() int { return len(h.entries) }
+func (h heartbeatHeap) Less(i, j int) bool {
+	return h.manager.deadline(h.entries[i]).Before(h.manager.deadline(h.entries[j]))
+}
+func (h heartbeatHeap) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+	h.entries[i].index, h.entries[j].index = i, j
+}
+func (h *heartbeatHeap) Push(x interface{}) {
+	entry := x.(*heartbeatEntry)
+	entry.index = len(h.entries)
+	h.entries = append(h.entries, entry)
+}
+func (h *heartbeatHeap) Pop() interface{} {
+	old := h.entries
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	h.entries = old[:n-1]
+	return entry
+}
+
+// heartbeatManager runs one goroutine per server that pings or times out
+// idle sessions, instead of a timer goroutine per session.
+type heartbeatManager struct {
+	config HeartbeatConfig
+
+	mutex    sync.Mutex
+	heap     heartbeatHeap
+	entries  map[uint64]*heartbeatEntry
+	wake     chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// SetHeartbeat installs a heartbeat manager with the given config and starts
+// its goroutine. Call it before Handle. The manager only tracks a session
+// once TouchSession has been called for it at least once; Server does this
+// itself when a session starts, and the session's own read/write loop
+// should call server.TouchSession(session) on every successful read or
+// write so idle peers are detected accurately.
+func (server *Server) SetHeartbeat(config HeartbeatConfig) {
+	manager := &heartbeatManager{
+		config:  config,
+		entries: make(map[uint64]*heartbeatEntry),
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+	}
+	manager.heap.manager = manager
+	server.heartbeat = manager
+	server.OnSessionClose(manager.forget)
+	go manager.run()
+}
+
+// TouchSession records activity for session, resetting its idle deadline.
+// It is a no-op if the server has no heartbeat manager installed. Call it
+// from a session's read/write loop on every successful read or write so
+// idle peers are detected without a per-session timer goroutine.
+func (server *Server) TouchSession(session *Session) {
+	if server.heartbeat != nil {
+		server.heartbeat.touch(session)
+	}
+}
+
+// touch records activity for session, resetting its idle deadline.
+func (manager *heartbeatManager) touch(session *Session) {
+	if manager.config.IdleTimeout <= 0 && manager.config.PingInterval <= 0 {
+		// nothing to track: no idle timeout and no ping to schedule
+		return
+	}
+
+	now := time.Now()
+
+	manager.mutex.Lock()
+	entry, exists := manager.entries[session.id]
+	if !exists {
+		entry = &heartbeatEntry{session: session, nextPing: now.Add(manager.config.PingInterval)}
+		manager.entries[session.id] = entry
+		heap.Push(&manager.heap, entry)
+	}
+	entry.lastActivity = now
+	heap.Fix(&manager.heap, entry.index)
+	manager.mutex.Unlock()
+
+	if !exists {
+		// Only a freshly inserted entry can move the head-of-heap deadline
+		// earlier; bumping lastActivity on an existing entry only pushes its
+		// idle deadline later and never changes what run() is waiting on, so
+		// waking it here would just be a pointless timer rebuild on every
+		// read/write of every session.
+		manager.wakeUp()
+	}
+}
+
+// forget removes a session from the heap, called when the session closes.
+func (manager *heartbeatManager) forget(session *Session) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	entry, exists := manager.entries[session.id]
+	if !exists {
+		return
+	}
+	heap.Remove(&manager.heap, entry.index)
+	delete(manager.entries, session.id)
+}
+
+func (manager *heartbeatManager) wakeUp() {
+	select {
+	case manager.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run sleeps until the next entry's deadline (or an early wake from touch
+// when a new session is added), handles whatever is due, then sleeps again.
+func (manager *heartbeatManager) run() {
+	for {
+		manager.mutex.Lock()
+		var timer <-chan time.Time
+		if manager.heap.Len() > 0 {
+			timer = time.After(time.Until(manager.deadline(manager.heap.entries[0])))
+		}
+		manager.mutex.Unlock()
+
+		select {
+		case <-manager.stop:
+			return
+		case <-manager.wake:
+		case <-timer:
+			manager.tick()
+		}
+	}
+}
+
+// tick handles every entry whose deadline has already passed: idle sessions
+// are closed and dropped, others get a ping and their ping deadline bumped.
+func (manager *heartbeatManager) tick() {
+	now := time.Now()
+
+	var timedOut, pinged []*Session
+
+	manager.mutex.Lock()
+	for manager.heap.Len() > 0 {
+		entry := manager.heap.entries[0]
+		if manager.deadline(entry).After(now) {
+			break
+		}
+
+		if manager.config.IdleTimeout > 0 && now.Sub(entry.lastActivity) >= manager.config.IdleTimeout {
+			heap.Remove(&manager.heap, entry.index)
+			delete(manager.entries, entry.session.id)
+			timedOut = append(timedOut, entry.session)
+			continue
+		}
+
+		entry.nextPing = now.Add(manager.config.PingInterval)
+		heap.Fix(&manager.heap, entry.index)
+		pinged = append(pinged, entry.session)
+	}
+	manager.mutex.Unlock()
+
+	for _, session := range timedOut {
+		session.Close()
+	}
+	if manager.config.PingPacket != nil {
+		for _, session := range pinged {
+			session.Send(manager.config.PingPacket())
+		}
+	}
+}
+
+// Stop shuts down the heartbeat manager's goroutine. Safe to call more than
+// once (e.g. from both Shutdown's drain path and a concurrent Stop()).
+func (manager *heartbeatManager) Stop() {
+	manager.stopOnce.Do(func() {
+		close(manager.stop)
+	})
+}
@@ -1,14 +1,28 @@
 package link
 
 import (
+	"context"
 	"net"
 	"sync"
 	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
 )
 
 // Default send chan buffer size for sessions.
 var DefaultSendChanSize uint = 1024
 
+// serverState is the lifecycle state of a Server, stored in Server.state.
+type serverState int32
+
+const (
+	stateNew serverState = iota
+	stateRunning
+	stateShuttingDown
+	stateStopped
+)
+
 // Server.
 type Server struct {
 	// About network
@@ -18,15 +32,31 @@ type Server struct {
 
 	// About sessions
 	sendChanSize uint
-	maxSessionId uint64
+	maxSessionId atomic.Uint64
 	sessions     map[uint64]*Session
 	sessionMutex sync.Mutex
 
 	// About server start and stop
 	stopChan chan int
-	stopFlag int32
+	state    atomic.Int32
 	stopWait *sync.WaitGroup
 
+	// Session lifecycle hooks
+	onAccept       func(net.Conn) (net.Conn, error)
+	onSessionClose []func(*Session)
+	rejectHandler  func(net.Conn)
+
+	// Accept-loop concurrency limiter
+	acceptSem   chan struct{}
+	acceptLimit *rate.Limiter
+
+	// Channel groups
+	channels      map[string]*Channel
+	channelsMutex sync.Mutex
+
+	// Idle timeout / ping heartbeat, set via SetHeartbeat
+	heartbeat *heartbeatManager
+
 	// Put your server state here.
 	State interface{}
 }
@@ -38,11 +68,10 @@ func NewServer(listener net.Listener, protocol PacketProtocol) *Server {
 		protocol:     protocol,
 		writer:       protocol.NewWriter(),
 		sendChanSize: DefaultSendChanSize,
-		maxSessionId: 0,
 		sessions:     make(map[uint64]*Session),
 		stopChan:     make(chan int),
 		stopWait:     new(sync.WaitGroup),
-		stopFlag:     -1,
+		channels:     make(map[string]*Channel),
 	}
 }
 
@@ -62,28 +91,189 @@ func (server *Server) GetSendChanSize() uint {
 	return server.sendChanSize
 }
 
+// OnAccept registers a hook run for every accepted connection, before the
+// session is created. It may wrap the conn (e.g. TLS, proxy protocol) or
+// reject it by returning a non-nil error, in which case the conn is closed
+// and no session is created for it.
+func (server *Server) OnAccept(hook func(net.Conn) (net.Conn, error)) {
+	server.onAccept = hook
+}
+
+// OnSessionClose registers a hook run from delSession whenever a session is
+// removed from the server, e.g. for metrics or per-IP accounting. It may be
+// called more than once; every registered hook runs.
+func (server *Server) OnSessionClose(hook func(*Session)) {
+	server.onSessionClose = append(server.onSessionClose, hook)
+}
+
+// SetMaxSessions caps the number of concurrently running sessions. Once the
+// cap is reached, acceptLoop blocks accepting new connections until a slot
+// frees up, unless a RejectHandler is set, in which case new conns are
+// closed immediately instead of causing backpressure. n <= 0 means no cap.
+func (server *Server) SetMaxSessions(n int) {
+	if n <= 0 {
+		server.acceptSem = nil
+		return
+	}
+	server.acceptSem = make(chan struct{}, n)
+}
+
+// SetAcceptRateLimit caps how fast acceptLoop hands new connections off to
+// startSession, smoothing bursts of TCP opens (e.g. slowloris clients).
+func (server *Server) SetAcceptRateLimit(r rate.Limit, burst int) {
+	server.acceptLimit = rate.NewLimiter(r, burst)
+}
+
+// RejectHandler registers a callback invoked with any conn that is turned
+// away because SetMaxSessions' limit has been reached. If unset, a full
+// session table simply blocks acceptLoop instead of rejecting.
+func (server *Server) RejectHandler(handler func(net.Conn)) {
+	server.rejectHandler = handler
+}
+
 // Handle incoming connections. The callback will called asynchronously when each session start.
-func (server *Server) Handle(callback func(*Session)) {
-	if atomic.CompareAndSwapInt32(&server.stopFlag, -1, 0) {
-		server.acceptLoop(callback)
-	} else {
-		panic(ServerDuplicateStartError)
+// Handle blocks until the server is stopped. It returns ErrServerClosed once
+// the accept loop has ended and every session has exited, whether the loop
+// ended through Stop or Shutdown or because the listener's Accept failed (the
+// Accept error is not returned), or ServerDuplicateStartError immediately if
+// the server was already started. This is a plain atomic CAS rather than a
+// sync.Once guard: a sync.Once would make a concurrent duplicate call block
+// for the server's entire lifetime waiting on Once.Do, instead of rejecting
+// the duplicate start right away.
+func (server *Server) Handle(callback func(*Session)) error {
+	if !server.state.CompareAndSwap(int32(stateNew), int32(stateRunning)) {
+		return ServerDuplicateStartError
 	}
+	server.acceptLoop(callback)
+	return ErrServerClosed
 }
 
-// Stop server.
+// Stop server immediately: the listener and every in-flight session are
+// closed right away, without waiting for queued sends to flush. Use
+// Shutdown for a graceful drain.
+//
+// Stop does not use a sync.Once guard either, for its own reason: Stop
+// must stay safely callable before Handle (where it's a no-op, see below)
+// without permanently spending a one-shot guard that a later, real Stop
+// (once the server is actually running) would need. The CAS loop below
+// gives the same "only one caller does the work" guarantee Once would,
+// while still allowing a pre-start Stop() to be a true no-op.
 func (server *Server) Stop() {
-	if atomic.CompareAndSwapInt32(&server.stopFlag, 0, 1) {
-		// if stop server without this goroutine
-		// deadlock will happen when server closed by session.
-		go func() {
-			// wait for accept loop exit
-			server.listener.Close()
-			<-server.stopChan
+	for {
+		old := serverState(server.state.Load())
+		if old == stateNew || old == stateStopped {
+			// never started, or already stopped: nothing to do. Leaving the
+			// state as-is (rather than forcing stateStopped) means a server
+			// that's never been Handle'd can still be started later.
+			return
+		}
+		if server.state.CompareAndSwap(int32(old), int32(stateStopped)) {
+			break
+		}
+	}
 
-			// close all sessions
-			server.closeSessions()
-		}()
+	if server.heartbeat != nil {
+		server.heartbeat.Stop()
+	}
+
+	// if stop server without this goroutine
+	// deadlock will happen when server closed by session.
+	go func() {
+		// wait for accept loop exit
+		server.listener.Close()
+		<-server.stopChan
+
+		// close all sessions
+		server.closeSessions()
+	}()
+}
+
+// drainPollInterval is how often Shutdown checks whether a session's
+// outbound send channel has emptied out.
+const drainPollInterval = 20 * time.Millisecond
+
+// Shutdown gracefully stops the server: acceptLoop stops taking new
+// connections, and every currently registered session is watched until its
+// outbound send channel empties out, at which point it is closed. If ctx
+// expires first, Shutdown falls back to the immediate Stop() and returns
+// ctx.Err().
+func (server *Server) Shutdown(ctx context.Context) error {
+	if !server.state.CompareAndSwap(int32(stateRunning), int32(stateShuttingDown)) {
+		// never started, or already shutting down/stopped: nothing graceful to do
+		server.Stop()
+		return nil
+	}
+
+	server.listener.Close()
+
+	for _, session := range server.Sessions() {
+		go server.drainSession(ctx, session)
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		server.stopWait.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		server.state.Store(int32(stateStopped))
+		if server.heartbeat != nil {
+			server.heartbeat.Stop()
+		}
+		return nil
+	case <-ctx.Done():
+		server.Stop()
+		return ctx.Err()
+	}
+}
+
+// drainSession waits until session's outbound send channel has flushed (or
+// the session closes on its own), then closes it. If ctx expires first it
+// returns without closing the session, leaving that to Stop. Without
+// this, a session that's still connected but idle would never finish and
+// release its stopWait slot, so Shutdown's graceful path would just hang
+// until ctx expired and fell through to the hard Stop() it's meant to avoid.
+func (server *Server) drainSession(ctx context.Context, session *Session) {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if session.IsClosed() || len(session.sendChan) == 0 {
+			session.Close()
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sessions returns a snapshot of the currently registered sessions.
+func (server *Server) Sessions() []*Session {
+	server.sessionMutex.Lock()
+	defer server.sessionMutex.Unlock()
+
+	sessions := make([]*Session, 0, len(server.sessions))
+	for _, session := range server.sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+// Range calls fn for every registered session, stopping early if fn returns
+// false. The sessions are copied under sessionMutex and fn is then invoked
+// lock-free, so fn may safely call session.Close() without deadlocking
+// against delSession.
+func (server *Server) Range(fn func(*Session) bool) {
+	for _, session := range server.Sessions() {
+		if !fn(session) {
+			return
+		}
 	}
 }
 
@@ -91,7 +281,9 @@ func (server *Server) Stop() {
 func (server *Server) acceptLoop(callback func(*Session)) {
 	defer func() {
 		close(server.stopChan)
-		server.Stop()
+		if serverState(server.state.Load()) != stateShuttingDown {
+			server.Stop()
+		}
 
 		// wait for all session exit
 		server.stopWait.Wait()
@@ -102,6 +294,39 @@ func (server *Server) acceptLoop(callback func(*Session)) {
 		if err != nil {
 			break
 		}
+
+		if server.acceptLimit != nil {
+			if err := server.acceptLimit.Wait(context.Background()); err != nil {
+				conn.Close()
+				continue
+			}
+		}
+
+		if server.onAccept != nil {
+			wrapped, err := server.onAccept(conn)
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			conn = wrapped
+		}
+
+		if server.acceptSem != nil {
+			select {
+			case server.acceptSem <- struct{}{}:
+				// got a slot, fall through to start the session
+			default:
+				if server.rejectHandler == nil {
+					// backpressure: block until a session exits and frees a slot
+					server.acceptSem <- struct{}{}
+				} else {
+					server.rejectHandler(conn)
+					conn.Close()
+					continue
+				}
+			}
+		}
+
 		go server.startSession(conn, callback)
 	}
 }
@@ -109,13 +334,19 @@ func (server *Server) acceptLoop(callback func(*Session)) {
 // Start a session to present the connection.
 func (server *Server) startSession(conn net.Conn, callback func(*Session)) {
 	session := NewSession(
-		atomic.AddUint64(&server.maxSessionId, 1),
+		server.nextSessionId(),
 		conn,
 		server.protocol,
 		server.sendChanSize,
 	)
 	session.server = server
 
+	// Session.Close calls server.delSession whenever session.server is set,
+	// even if the callback below closes the session before Start ever runs
+	// — so the matching stopWait.Add must happen before the callback, not
+	// after, or a callback-time Close races a Done() with no Add behind it.
+	server.stopWait.Add(1)
+
 	// init the session state
 	if callback != nil {
 		callback(session)
@@ -124,34 +355,59 @@ func (server *Server) startSession(conn net.Conn, callback func(*Session)) {
 	// session maybe closed in start callback
 	if !session.IsClosed() {
 		server.putSession(session)
+		server.TouchSession(session)
 		session.Start()
 	}
 }
 
+// nextSessionId allocates the next session id.
+func (server *Server) nextSessionId() uint64 {
+	return server.maxSessionId.Add(1)
+}
+
 // Put a session into session list
 func (server *Server) putSession(session *Session) {
-	if atomic.LoadInt32(&server.stopFlag) == 0 {
+	if serverState(server.state.Load()) == stateRunning {
 		server.sessionMutex.Lock()
 		defer server.sessionMutex.Unlock()
 
 		server.sessions[session.id] = session
 	}
-
-	server.stopWait.Add(1)
 }
 
 // Delete a session from session list
 func (server *Server) delSession(session *Session) {
-	if atomic.LoadInt32(&server.stopFlag) == 0 {
+	if serverState(server.state.Load()) == stateRunning || serverState(server.state.Load()) == stateShuttingDown {
 		server.sessionMutex.Lock()
-		defer server.sessionMutex.Unlock()
-
 		delete(server.sessions, session.id)
+		server.sessionMutex.Unlock()
+	}
+
+	server.leaveAllChannels(session)
+	server.releaseAcceptSlot()
+
+	for _, hook := range server.onSessionClose {
+		hook(session)
 	}
 
 	server.stopWait.Done()
 }
 
+// releaseAcceptSlot frees one slot in the accept-concurrency semaphore, the
+// counterpart to the slot acceptLoop reserves for every accepted conn. It is
+// called from exactly one place, delSession, since Session.Close always
+// ends up calling delSession exactly once for a session whose server field
+// is set — releasing it anywhere else would double-free the slot.
+func (server *Server) releaseAcceptSlot() {
+	if server.acceptSem == nil {
+		return
+	}
+	select {
+	case <-server.acceptSem:
+	default:
+	}
+}
+
 // Close all sessions.
 func (server *Server) closeSessions() {
 	server.sessionMutex.Lock()